@@ -0,0 +1,30 @@
+//go:build unix
+
+package log
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file by device and inode, which stays stable across
+// renames on the same filesystem.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func getFileID(f *os.File) (fileID, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return fileID{}, err
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, errors.New("log: file identity unavailable for this filesystem")
+	}
+
+	return fileID{dev: uint64(st.Dev), ino: st.Ino}, nil
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package log
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileID identifies a file by volume serial number and file index, which
+// stays stable across renames on the same volume.
+type fileID struct {
+	volumeSerial uint32
+	fileIndex    uint64
+}
+
+func getFileID(f *os.File) (fileID, error) {
+	var info windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(windows.Handle(f.Fd()), &info); err != nil {
+		return fileID{}, err
+	}
+
+	return fileID{
+		volumeSerial: info.VolumeSerialNumber,
+		fileIndex:    uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow),
+	}, nil
+}
@@ -1,19 +1,37 @@
 package log
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/xenking/log/filelock"
 )
 
+// tmpSuffix marks a rotated file as still being written. rotate() only
+// publishes it under its final name, alongside a checksum sidecar, once it
+// has been closed and fsynced.
+const tmpSuffix = ".tmp"
+
 type FastFileWriter struct {
-	size int64
-	file atomic.Value // *os.File
-	mu   sync.Mutex   // For create/rotate/close
+	size    int64
+	created int64        // unix nano of the currently open file's creation time
+	file    atomic.Value // *os.File
+	mu      sync.Mutex   // For create/rotate/close
+
+	tickerOnce sync.Once
+	closeOnce  sync.Once
+	done       chan struct{} // closed by Close to stop ageLoop
+	lockFile   atomic.Value  // *os.File, lazily opened FileLock handle
 
 	Filename string
 
@@ -21,6 +39,10 @@ type FastFileWriter struct {
 
 	MaxBackups int
 
+	// MaxAge rotates the currently open file once it has been open longer
+	// than this duration. Checked by a background ticker, not on every Write.
+	MaxAge time.Duration
+
 	FileMode os.FileMode
 
 	LocalTime bool
@@ -28,6 +50,20 @@ type FastFileWriter struct {
 	HostName bool
 
 	ProcessID bool
+
+	// Compress gzips rotated files to ".gz" in the background after they
+	// are closed. rotate() counts ".gz" archives against MaxBackups whether
+	// or not Compress is set.
+	Compress bool
+
+	// FileLock serializes create/rotate across processes sharing Filename
+	// with an OS-level advisory lock, on top of the in-process mu.
+	FileLock bool
+
+	// OnRotate, if set, is called in the background with the final path of
+	// each rotated file once it is safely on disk (after Compress, if
+	// enabled). Pair it with an upload.Queue to ship rotated logs off-host.
+	OnRotate func(path string) error
 }
 
 func (w *FastFileWriter) Write(p []byte) (n int, err error) {
@@ -52,6 +88,16 @@ func (w *FastFileWriter) Write(p []byte) (n int, err error) {
 		n, err = file.(*os.File).Write(p)
 	}
 
+	if w.MaxAge > 0 {
+		w.tickerOnce.Do(func() {
+			w.mu.Lock()
+			w.done = make(chan struct{})
+			done := w.done
+			w.mu.Unlock()
+			go w.ageLoop(done)
+		})
+	}
+
 	if w.MaxSize > 0 && atomic.AddInt64(&w.size, int64(n)) > w.MaxSize {
 		w.mu.Lock()
 		// double check
@@ -67,10 +113,33 @@ func (w *FastFileWriter) Write(p []byte) (n int, err error) {
 func (w *FastFileWriter) Close() (err error) {
 	w.mu.Lock()
 
+	w.closeOnce.Do(func() {
+		if w.done != nil {
+			close(w.done)
+		}
+	})
+	atomic.StoreInt64(&w.created, 0)
+
 	file := w.file.Load()
 	if file != nil {
-		err = file.(*os.File).Close()
+		oldFile := file.(*os.File)
+		// Snapshot identity before finalize renames oldFile's tmp path away,
+		// same as rotate: Filename still points at the tmp name at this point.
+		snap := w.snapshotRelink(oldFile)
+
+		var finalName string
+		finalName, err = w.finalize(oldFile)
 		atomic.StoreInt64(&w.size, 0)
+
+		if err == nil && finalName != "" {
+			w.relink(finalName, snap)
+		}
+	}
+
+	if v := w.lockFile.Load(); v != nil {
+		lf := v.(*os.File)
+		w.unlock(lf)
+		lf.Close()
 	}
 
 	w.mu.Unlock()
@@ -84,22 +153,82 @@ func (w *FastFileWriter) Rotate() (err error) {
 	return
 }
 
+// ageLoop rotates the currently open file once it exceeds MaxAge. It is
+// started lazily by the first Write and runs until done is closed by Close.
+func (w *FastFileWriter) ageLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		created := atomic.LoadInt64(&w.created)
+		if created == 0 {
+			continue
+		}
+
+		now := timeNow()
+		if !w.LocalTime {
+			now = now.UTC()
+		}
+
+		if now.Sub(time.Unix(0, created)) < w.MaxAge {
+			continue
+		}
+
+		w.mu.Lock()
+		// double check, another goroutine may have already rotated or closed
+		if atomic.LoadInt64(&w.created) != 0 && time.Unix(0, atomic.LoadInt64(&w.created)).Add(w.MaxAge).Before(now) {
+			w.rotate()
+		}
+		w.mu.Unlock()
+	}
+}
+
 func (w *FastFileWriter) rotate() error {
-	file, err := os.OpenFile(w.openinfo(timeNow()))
+	now := timeNow()
+	name, flag, perm := w.openinfo(now)
+	name = uniqueName(name)
+	file, err := os.OpenFile(name+tmpSuffix, flag, perm)
 	if err != nil {
 		return err
 	}
 
-	oldfile := w.file.Load()
+	var oldFile *os.File
+	if v := w.file.Load(); v != nil {
+		oldFile = v.(*os.File)
+	}
+	// Snapshot identity before finalize renames oldFile's tmp path away:
+	// once that happens, Filename (which still points at the old tmp name)
+	// can no longer be resolved by path.
+	snap := w.snapshotRelink(oldFile)
+
 	w.file.Store(file)
 	atomic.StoreInt64(&w.size, 0)
+	atomic.StoreInt64(&w.created, now.UnixNano())
 
-	if oldfile != nil {
-		oldfile.(*os.File).Close()
+	// Hold the FileLock across finalize's publishing rename as well as the
+	// symlink swap below: both need to be serialized against other
+	// processes sharing Filename, not just the symlink swap.
+	lock, err := w.lock()
+	if err != nil {
+		return err
 	}
+	defer w.unlock(lock)
 
-	os.Remove(w.Filename)
-	os.Symlink(filepath.Base(file.Name()), w.Filename)
+	var oldname string
+	if oldFile != nil {
+		oldname, err = w.finalize(oldFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.relink(file.Name(), snap)
 
 	uid, _ := strconv.Atoi(os.Getenv("SUDO_UID"))
 	gid, _ := strconv.Atoi(os.Getenv("SUDO_GID"))
@@ -108,32 +237,279 @@ func (w *FastFileWriter) rotate() error {
 		os.Chown(file.Name(), uid, gid)
 	}
 
+	if w.Compress && oldname != "" {
+		go w.compress(oldname)
+	} else if oldname != "" {
+		go w.notifyRotate(oldname)
+	}
+
+	w.prune()
+
+	return nil
+}
+
+// prune removes rotated backups beyond MaxBackups, counting ".gz" archives
+// alongside their uncompressed form.
+func (w *FastFileWriter) prune() {
 	ext := filepath.Ext(w.Filename)
 	pattern := w.Filename[0:len(w.Filename)-len(ext)] + ".20*" + ext
-	if names, _ := filepath.Glob(pattern); len(names) > 0 {
-		sort.Strings(names)
-		for i := 0; i < len(names)-w.MaxBackups-1; i++ {
-			os.Remove(names[i])
-		}
+
+	names, _ := filepath.Glob(pattern)
+	if gzNames, _ := filepath.Glob(pattern + ".gz"); len(gzNames) > 0 {
+		names = append(names, gzNames...)
+	}
+	if len(names) == 0 {
+		return
 	}
 
-	return nil
+	sort.Strings(names)
+	for i := 0; i < len(names)-w.MaxBackups; i++ {
+		os.Remove(names[i])
+		os.Remove(names[i] + ".sha256")
+	}
+}
+
+// compress gzips the rotated file at path to path+".gz", rewrites the
+// SHA-256 sidecar to cover the compressed artifact (finalize's sidecar
+// checksums the uncompressed bytes, but compress removes those once
+// gzipping succeeds), and removes the original on success. It runs off the
+// write path and never blocks Write.
+func (w *FastFileWriter) compress(path string) {
+	src, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_EXCL, w.perm())
+	if err != nil {
+		return
+	}
+
+	gw := gzip.NewWriter(dst)
+	_, err = io.Copy(gw, src)
+	if cerr := gw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return
+	}
+
+	os.Remove(path)
+	os.Remove(path + ".sha256")
+
+	if sum, err := sumFile(path + ".gz"); err == nil {
+		line := hex.EncodeToString(sum) + "  " + filepath.Base(path) + ".gz\n"
+		os.WriteFile(path+".gz.sha256", []byte(line), w.perm())
+	}
+
+	w.notifyRotate(path + ".gz")
+}
+
+// notifyRotate calls OnRotate, if set, discarding its error: w has no
+// logging facility of its own to report upload failures to.
+func (w *FastFileWriter) notifyRotate(path string) {
+	if w.OnRotate != nil {
+		w.OnRotate(path)
+	}
 }
 
 func (w *FastFileWriter) create() (*os.File, error) {
-	file, err := os.OpenFile(w.openinfo(timeNow()))
+	now := timeNow()
+	name, flag, perm := w.openinfo(now)
+	name = uniqueName(name)
+	file, err := os.OpenFile(name+tmpSuffix, flag, perm)
 	if err != nil {
 		return nil, err
 	}
 	w.file.Store(file)
 	atomic.StoreInt64(&w.size, 0)
+	atomic.StoreInt64(&w.created, now.UnixNano())
 
-	os.Remove(w.Filename)
-	os.Symlink(filepath.Base(file.Name()), w.Filename)
+	lock, err := w.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer w.unlock(lock)
+
+	snap := w.snapshotRelink(nil)
+	w.relink(file.Name(), snap)
 
 	return file, nil
 }
 
+// relinkSnapshot captures the file identities relink needs to decide whether
+// to swap Filename's target, taken before finalize renames oldFile's tmp
+// path out from under it.
+type relinkSnapshot struct {
+	existingID fileID
+	existingOK bool
+	oldID      fileID
+	haveOldID  bool
+}
+
+// snapshotRelink records the identity of whatever Filename currently
+// resolves to, and of oldFile (the file being rotated out, if any), so
+// relink can be called after oldFile has been finalized and its original
+// path no longer exists.
+func (w *FastFileWriter) snapshotRelink(oldFile *os.File) relinkSnapshot {
+	var snap relinkSnapshot
+	snap.existingID, snap.existingOK = statFileID(w.Filename)
+	if oldFile != nil {
+		snap.oldID, snap.haveOldID = fileIDOf(oldFile)
+	}
+	return snap
+}
+
+// relink points Filename at name, replacing any existing symlink or file in
+// its place. If Filename already points at name (by path), the swap is
+// skipped; note rename preserves the inode, so comparing by fileID here
+// would wrongly treat a stale symlink to a since-renamed-away path as
+// already correct. If Filename resolves to something other than the file
+// being rotated out, relink refuses to touch it rather than risk unlinking
+// an unrelated file that happens to share the path. Filename is a
+// convenience for readers, not load-bearing for Write, so relink is
+// best-effort: a failure (e.g. no symlink privilege on Windows) must not
+// abort create/rotate/Write.
+func (w *FastFileWriter) relink(name string, snap relinkSnapshot) {
+	if target, err := os.Readlink(w.Filename); err == nil && target == filepath.Base(name) {
+		return
+	}
+
+	if snap.existingOK && snap.haveOldID && snap.existingID != snap.oldID {
+		return
+	}
+
+	os.Remove(w.Filename)
+	os.Symlink(filepath.Base(name), w.Filename)
+}
+
+func fileIDOf(f *os.File) (fileID, bool) {
+	id, err := getFileID(f)
+	return id, err == nil
+}
+
+func statFileID(path string) (fileID, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileID{}, false
+	}
+	defer f.Close()
+
+	id, err := getFileID(f)
+	return id, err == nil
+}
+
+// finalize closes file, which must already be fully written, and publishes
+// it under its final name (without tmpSuffix) alongside a SHA-256 sidecar.
+// The rename only happens after the sidecar is written and file is fsynced,
+// so a crash never leaves a reader looking at a half-written rotated file.
+// It returns the published name, or "" if file is nil.
+func (w *FastFileWriter) finalize(file *os.File) (string, error) {
+	if file == nil {
+		return "", nil
+	}
+
+	syncErr := file.Sync()
+	tmpName := file.Name()
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	if syncErr != nil {
+		return "", syncErr
+	}
+
+	finalName := strings.TrimSuffix(tmpName, tmpSuffix)
+	if finalName == tmpName {
+		return finalName, nil
+	}
+
+	// Hash the closed, fsynced file from disk rather than accumulating a
+	// running digest on every Write: Write is lock-free and can be called
+	// concurrently, so a shared hash.Hash there would race.
+	sum, err := sumFile(tmpName)
+	if err != nil {
+		return "", err
+	}
+
+	sidecar := finalName + ".sha256"
+	line := hex.EncodeToString(sum) + "  " + filepath.Base(finalName) + "\n"
+	if err := os.WriteFile(sidecar, []byte(line), w.perm()); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmpName, finalName); err != nil {
+		return "", err
+	}
+	// Best-effort: fsync the parent directory so the rename itself is
+	// durable across a crash, not just the file contents synced above.
+	syncDir(filepath.Dir(finalName))
+
+	return finalName, nil
+}
+
+// syncDir fsyncs dir so that directory-entry changes within it (renames,
+// creates) are durable, not just the files themselves.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// sumFile returns the SHA-256 digest of the file at path.
+func sumFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// lock acquires the cross-process FileLock, if enabled, over the publishing
+// rename in finalize, the symlink swap, and the backup-prune glob. It
+// returns the lock handle to pass to unlock, or nil if FileLock is
+// disabled. The handle is reused across calls and closed by Close.
+func (w *FastFileWriter) lock() (*os.File, error) {
+	if !w.FileLock {
+		return nil, nil
+	}
+
+	lf := w.lockFile.Load()
+	if lf == nil {
+		f, err := os.OpenFile(w.Filename+".lock", os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w.lockFile.Store(f)
+		lf = f
+	}
+
+	f := lf.(*os.File)
+	if err := filelock.Lock(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (w *FastFileWriter) unlock(f *os.File) {
+	if f != nil {
+		filelock.Unlock(f)
+	}
+}
+
 func (w *FastFileWriter) openinfo(now time.Time) (filename string, flag int, perm os.FileMode) {
 	if !w.LocalTime {
 		now = now.UTC()
@@ -157,11 +533,35 @@ func (w *FastFileWriter) openinfo(now time.Time) (filename string, flag int, per
 	}
 
 	flag = os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	perm = w.perm()
 
-	perm = w.FileMode
-	if perm == 0 {
-		perm = 0644
+	return
+}
+
+// uniqueName returns name, or a disambiguated variant of it, such that
+// neither name nor name+tmpSuffix already exists on disk. openinfo's names
+// only have one-second resolution, so two rotations within the same second
+// would otherwise collide: the second rotation would reopen the first
+// rotation's still-active tmp path, and finalize would later try to publish
+// both under the same final name.
+func uniqueName(name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(candidate + tmpSuffix); err != nil {
+			if _, err := os.Lstat(candidate); err != nil {
+				return candidate
+			}
+		}
+		candidate = base + "-" + strconv.Itoa(i) + ext
 	}
+}
 
-	return
-}
\ No newline at end of file
+func (w *FastFileWriter) perm() os.FileMode {
+	if w.FileMode != 0 {
+		return w.FileMode
+	}
+	return 0644
+}
@@ -0,0 +1,3 @@
+// Package filelock provides a minimal, per-OS advisory file lock used to
+// serialize access to a shared path across processes.
+package filelock
@@ -0,0 +1,34 @@
+//go:build plan9
+
+package filelock
+
+import (
+	"os"
+	"time"
+)
+
+// Plan 9 has no fcntl/flock equivalent, so Lock is emulated with an
+// exclusively-created lock file alongside f.
+
+func lockPath(f *os.File) string {
+	return f.Name() + ".lock"
+}
+
+// Lock acquires an exclusive lock on f, blocking until it becomes available.
+func Lock(f *os.File) error {
+	for {
+		lf, err := os.OpenFile(lockPath(f), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			return lf.Close()
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Unlock releases a lock previously acquired with Lock.
+func Unlock(f *os.File) error {
+	return os.Remove(lockPath(f))
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package filelock
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Lock acquires an exclusive, open-file-description lock on f, blocking
+// until it becomes available. It conflicts with fcntl locks taken through any
+// other open file description of the same file, including ones opened by
+// this process; locks taken through f's own open file description do not
+// conflict with it.
+func Lock(f *os.File) error {
+	lk := unix.Flock_t{
+		Type:  unix.F_WRLCK,
+		Start: 0,
+		Len:   0,
+	}
+	return unix.FcntlFlock(f.Fd(), unix.F_OFD_SETLKW, &lk)
+}
+
+// Unlock releases a lock previously acquired with Lock.
+func Unlock(f *os.File) error {
+	lk := unix.Flock_t{
+		Type:  unix.F_UNLCK,
+		Start: 0,
+		Len:   0,
+	}
+	return unix.FcntlFlock(f.Fd(), unix.F_OFD_SETLKW, &lk)
+}
@@ -0,0 +1,52 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// HTTP uploads rotated files with a PUT request to BaseURL plus the file's
+// base name.
+type HTTP struct {
+	Client  *http.Client
+	BaseURL string
+}
+
+func (u *HTTP) Upload(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	url := u.BaseURL + filepath.Base(path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fi.Size()
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("upload: PUT %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Package upload ships rotated log files to durable off-host storage.
+//
+// An Uploader knows how to put a single file somewhere; Queue wraps one with
+// retries and a bounded backlog so FastFileWriter.OnRotate never blocks on a
+// slow or unreachable backend.
+package upload
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Uploader puts the file at path somewhere durable.
+type Uploader interface {
+	Upload(ctx context.Context, path string) error
+}
+
+// Queue retries failed uploads with exponential backoff and bounds pending
+// work in memory, dropping the oldest queued path when full rather than
+// blocking the caller.
+type Queue struct {
+	uploader Uploader
+	pending  chan string
+	dropped  int64 // atomic
+
+	// MaxRetries is the number of retries after the first failed attempt.
+	MaxRetries int
+
+	// BaseBackoff and MaxBackoff bound the exponential backoff between
+	// retries.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// NewQueue starts a Queue of the given backlog size that uploads via u.
+func NewQueue(u Uploader, size int) *Queue {
+	q := &Queue{
+		uploader:    u,
+		pending:     make(chan string, size),
+		MaxRetries:  5,
+		BaseBackoff: time.Second,
+		MaxBackoff:  time.Minute,
+	}
+	go q.run()
+	return q
+}
+
+// OnRotate queues path for upload. It matches the signature of
+// FastFileWriter.OnRotate and never blocks or returns an error: a full queue
+// drops its oldest entry, counted in Dropped. If there is still no room after
+// that (another caller refilled the queue, or the queue has size 0 and its
+// worker is busy), path itself is dropped and counted rather than spinning
+// forever trying to make space.
+func (q *Queue) OnRotate(path string) error {
+	select {
+	case q.pending <- path:
+		return nil
+	default:
+	}
+
+	select {
+	case <-q.pending:
+		atomic.AddInt64(&q.dropped, 1)
+	default:
+	}
+
+	select {
+	case q.pending <- path:
+	default:
+		atomic.AddInt64(&q.dropped, 1)
+	}
+	return nil
+}
+
+// Dropped returns the number of paths discarded because the queue was full.
+func (q *Queue) Dropped() int64 {
+	return atomic.LoadInt64(&q.dropped)
+}
+
+func (q *Queue) run() {
+	for path := range q.pending {
+		q.upload(path)
+	}
+}
+
+func (q *Queue) upload(path string) {
+	backoff := q.BaseBackoff
+
+	for attempt := 0; ; attempt++ {
+		if err := q.uploader.Upload(context.Background(), path); err == nil {
+			return
+		}
+		if attempt >= q.MaxRetries {
+			return
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > q.MaxBackoff {
+			backoff = q.MaxBackoff
+		}
+	}
+}
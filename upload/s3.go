@@ -0,0 +1,33 @@
+package upload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3 uploads rotated files to a bucket, keyed by Prefix plus the file's
+// base name.
+type S3 struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string
+}
+
+func (u *S3) Upload(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = u.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(u.Bucket),
+		Key:    aws.String(u.Prefix + filepath.Base(path)),
+		Body:   f,
+	})
+	return err
+}
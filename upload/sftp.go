@@ -0,0 +1,34 @@
+package upload
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+)
+
+// SFTP uploads rotated files into Dir over an already-connected SFTP
+// client.
+type SFTP struct {
+	Client *sftp.Client
+	Dir    string
+}
+
+func (u *SFTP) Upload(ctx context.Context, p string) error {
+	src, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := u.Client.Create(path.Join(u.Dir, filepath.Base(p)))
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = dst.ReadFrom(src)
+	return err
+}